@@ -0,0 +1,292 @@
+package dynamicreplace
+
+import (
+	"bufio"
+	"container/list"
+	"context"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Cache is the subsystem used to avoid re-fetching enrichment data for a
+// uid on every request. Implementations must be safe for concurrent use.
+type Cache interface {
+	Get(ctx context.Context, key string) (map[string]interface{}, bool, error)
+	Set(ctx context.Context, key string, value map[string]interface{}, ttl time.Duration) error
+	Delete(ctx context.Context, key string) error
+}
+
+// counter is a process-lifetime count exported via expvar. Traefik's
+// Yaegi plugin loader interprets a plugin's source tree directly rather
+// than running "go mod download", so a reflection-heavy dependency like
+// the Prometheus client can't be used here; expvar is stdlib and gives
+// us the same "cheap counters visible from outside the process" need.
+type counter struct {
+	v expvar.Int
+}
+
+func newCounter(name string) *counter {
+	c := &counter{}
+	expvar.Publish(name, &c.v)
+	return c
+}
+
+func (c *counter) Inc() {
+	c.v.Add(1)
+}
+
+var (
+	cacheHits   = newCounter("dynamicreplace_cache_hits_total")
+	cacheMisses = newCounter("dynamicreplace_cache_misses_total")
+)
+
+// newCache builds the Cache implementation selected by config, defaulting
+// to the in-memory LRU when CacheType is unset.
+func newCache(config *Config) (Cache, error) {
+	switch config.CacheType {
+	case "", "memory":
+		maxEntries := config.CacheMaxEntries
+		if maxEntries <= 0 {
+			maxEntries = 1000
+		}
+		return newMemoryCache(maxEntries), nil
+	case "redis":
+		if config.RedisURL == "" {
+			return nil, fmt.Errorf("redisURL must be set when cacheType is \"redis\"")
+		}
+		return newRedisCache(config.RedisURL)
+	default:
+		return nil, fmt.Errorf("unknown cacheType %q", config.CacheType)
+	}
+}
+
+// memoryCache is an in-memory LRU cache with a per-entry TTL. Entries
+// past their TTL are treated as misses on read and evicted lazily.
+type memoryCache struct {
+	mu         sync.Mutex
+	maxEntries int
+	order      *list.List
+	items      map[string]*list.Element
+}
+
+type memoryCacheEntry struct {
+	key       string
+	value     map[string]interface{}
+	expiresAt time.Time
+}
+
+func newMemoryCache(maxEntries int) *memoryCache {
+	return &memoryCache{
+		maxEntries: maxEntries,
+		order:      list.New(),
+		items:      make(map[string]*list.Element),
+	}
+}
+
+func (c *memoryCache) Get(_ context.Context, key string) (map[string]interface{}, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.items[key]
+	if !ok {
+		return nil, false, nil
+	}
+	entry := elem.Value.(*memoryCacheEntry)
+	if time.Now().After(entry.expiresAt) {
+		c.order.Remove(elem)
+		delete(c.items, key)
+		return nil, false, nil
+	}
+	c.order.MoveToFront(elem)
+	return entry.value, true, nil
+}
+
+func (c *memoryCache) Set(_ context.Context, key string, value map[string]interface{}, ttl time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.items[key]; ok {
+		elem.Value.(*memoryCacheEntry).value = value
+		elem.Value.(*memoryCacheEntry).expiresAt = time.Now().Add(ttl)
+		c.order.MoveToFront(elem)
+		return nil
+	}
+
+	elem := c.order.PushFront(&memoryCacheEntry{key: key, value: value, expiresAt: time.Now().Add(ttl)})
+	c.items[key] = elem
+
+	for c.order.Len() > c.maxEntries {
+		oldest := c.order.Back()
+		if oldest == nil {
+			break
+		}
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(*memoryCacheEntry).key)
+	}
+	return nil
+}
+
+func (c *memoryCache) Delete(_ context.Context, key string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.items[key]; ok {
+		c.order.Remove(elem)
+		delete(c.items, key)
+	}
+	return nil
+}
+
+// redisCache stores entries as JSON-encoded strings with Redis's own
+// TTL (EX) handling expiry, so there is no local eviction logic. It
+// speaks the RESP protocol directly over a single persistent
+// connection instead of depending on go-redis: go-redis leans on
+// reflection-heavy init that Yaegi (Traefik's plugin interpreter) has
+// historically choked on, and this subset of RESP is small enough to
+// own directly.
+type redisCache struct {
+	mu   sync.Mutex
+	addr string
+	conn net.Conn
+	br   *bufio.Reader
+}
+
+func newRedisCache(rawURL string) (*redisCache, error) {
+	addr := rawURL
+	if u, err := url.Parse(rawURL); err == nil && u.Host != "" {
+		addr = u.Host
+	}
+	return &redisCache{addr: addr}, nil
+}
+
+func (c *redisCache) Get(_ context.Context, key string) (map[string]interface{}, bool, error) {
+	reply, err := c.do("GET", key)
+	if err != nil {
+		return nil, false, err
+	}
+	if reply == nil {
+		return nil, false, nil
+	}
+	raw, ok := reply.([]byte)
+	if !ok {
+		return nil, false, fmt.Errorf("dynamicreplace: unexpected redis GET reply %v", reply)
+	}
+
+	var value map[string]interface{}
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return nil, false, err
+	}
+	return value, true, nil
+}
+
+func (c *redisCache) Set(_ context.Context, key string, value map[string]interface{}, ttl time.Duration) error {
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	seconds := int64(ttl / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+	_, err = c.do("SET", key, string(raw), "EX", strconv.FormatInt(seconds, 10))
+	return err
+}
+
+func (c *redisCache) Delete(_ context.Context, key string) error {
+	_, err := c.do("DEL", key)
+	return err
+}
+
+// do sends a RESP command and returns its decoded reply: a simple
+// status string, an int64, a []byte for a bulk string, or nil for a nil
+// bulk string. The connection is held open across calls and reopened on
+// the next call after any error.
+func (c *redisCache) do(args ...string) (interface{}, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.conn == nil {
+		conn, err := net.Dial("tcp", c.addr)
+		if err != nil {
+			return nil, fmt.Errorf("dial redis: %w", err)
+		}
+		c.conn = conn
+		c.br = bufio.NewReader(conn)
+	}
+
+	if err := writeRESPCommand(c.conn, args); err != nil {
+		c.conn = nil
+		return nil, err
+	}
+
+	reply, err := readRESPReply(c.br)
+	if err != nil {
+		c.conn = nil
+		return nil, err
+	}
+	return reply, nil
+}
+
+// writeRESPCommand writes args to w as a RESP array of bulk strings,
+// the wire form Redis expects for a client command.
+func writeRESPCommand(w net.Conn, args []string) error {
+	var buf strings.Builder
+	fmt.Fprintf(&buf, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&buf, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	_, err := w.Write([]byte(buf.String()))
+	return err
+}
+
+// readRESPReply parses a single RESP reply: simple string (+), error
+// (-), integer (:), or bulk string ($, -1 length meaning nil). Arrays
+// are not needed by any command this client issues.
+func readRESPReply(r *bufio.Reader) (interface{}, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimRight(line, "\r\n")
+	if line == "" {
+		return nil, errors.New("dynamicreplace: empty redis reply")
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, fmt.Errorf("dynamicreplace: redis error: %s", line[1:])
+	case ':':
+		n, err := strconv.ParseInt(line[1:], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redis integer reply: %w", err)
+		}
+		return n, nil
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid redis bulk length: %w", err)
+		}
+		if n == -1 {
+			return nil, nil
+		}
+		payload := make([]byte, n+2) // +2 for the trailing \r\n
+		if _, err := io.ReadFull(r, payload); err != nil {
+			return nil, err
+		}
+		return payload[:n], nil
+	default:
+		return nil, fmt.Errorf("dynamicreplace: unsupported redis reply type %q", line[0])
+	}
+}
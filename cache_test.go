@@ -0,0 +1,159 @@
+package dynamicreplace
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMemoryCacheSetGet(t *testing.T) {
+	c := newMemoryCache(10)
+	ctx := context.Background()
+
+	value := map[string]interface{}{"name": "ada"}
+	if err := c.Set(ctx, "k", value, time.Minute); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+
+	got, ok, err := c.Get(ctx, "k")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if !ok {
+		t.Fatal("Get() ok = false, want true")
+	}
+	if got["name"] != "ada" {
+		t.Errorf("Get() = %v, want %v", got, value)
+	}
+}
+
+func TestMemoryCacheExpiresEntries(t *testing.T) {
+	c := newMemoryCache(10)
+	ctx := context.Background()
+
+	if err := c.Set(ctx, "k", map[string]interface{}{"v": 1}, time.Millisecond); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	time.Sleep(5 * time.Millisecond)
+
+	_, ok, err := c.Get(ctx, "k")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if ok {
+		t.Error("Get() ok = true for an expired entry, want false")
+	}
+}
+
+func TestMemoryCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	c := newMemoryCache(2)
+	ctx := context.Background()
+
+	must := func(err error) {
+		if err != nil {
+			t.Fatalf("Set: %v", err)
+		}
+	}
+	must(c.Set(ctx, "a", map[string]interface{}{"v": "a"}, time.Minute))
+	must(c.Set(ctx, "b", map[string]interface{}{"v": "b"}, time.Minute))
+	must(c.Set(ctx, "c", map[string]interface{}{"v": "c"}, time.Minute))
+
+	if _, ok, _ := c.Get(ctx, "a"); ok {
+		t.Error("Get(\"a\") ok = true, want evicted")
+	}
+	if _, ok, _ := c.Get(ctx, "b"); !ok {
+		t.Error("Get(\"b\") ok = false, want still present")
+	}
+	if _, ok, _ := c.Get(ctx, "c"); !ok {
+		t.Error("Get(\"c\") ok = false, want still present")
+	}
+}
+
+func TestMemoryCacheDelete(t *testing.T) {
+	c := newMemoryCache(10)
+	ctx := context.Background()
+
+	if err := c.Set(ctx, "k", map[string]interface{}{"v": 1}, time.Minute); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	if err := c.Delete(ctx, "k"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, ok, _ := c.Get(ctx, "k"); ok {
+		t.Error("Get() ok = true after Delete, want false")
+	}
+}
+
+func TestCallGroupCoalescesConcurrentCalls(t *testing.T) {
+	var g callGroup
+	var calls int32
+
+	const concurrency = 20
+	var wg sync.WaitGroup
+	results := make([]interface{}, concurrency)
+
+	start := make(chan struct{})
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			<-start
+			v, err := g.Do("shared-key", func() (interface{}, error) {
+				atomic.AddInt32(&calls, 1)
+				time.Sleep(10 * time.Millisecond)
+				return "result", nil
+			})
+			if err != nil {
+				t.Errorf("Do: %v", err)
+			}
+			results[i] = v
+		}(i)
+	}
+	close(start)
+	wg.Wait()
+
+	if calls != 1 {
+		t.Errorf("fn called %d times, want 1", calls)
+	}
+	for i, v := range results {
+		if v != "result" {
+			t.Errorf("results[%d] = %v, want %q", i, v, "result")
+		}
+	}
+}
+
+func TestCallGroupPropagatesError(t *testing.T) {
+	var g callGroup
+	wantErr := errors.New("boom")
+
+	_, err := g.Do("key", func() (interface{}, error) {
+		return nil, wantErr
+	})
+	if !errors.Is(err, wantErr) {
+		t.Errorf("Do() err = %v, want %v", err, wantErr)
+	}
+}
+
+func TestCallGroupRunsAgainAfterPriorCallCompletes(t *testing.T) {
+	var g callGroup
+	var calls int32
+
+	do := func() (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		return "v", nil
+	}
+
+	if _, err := g.Do("key", do); err != nil {
+		t.Fatalf("Do: %v", err)
+	}
+	if _, err := g.Do("key", do); err != nil {
+		t.Fatalf("Do: %v", err)
+	}
+
+	if calls != 2 {
+		t.Errorf("fn called %d times across two sequential calls, want 2", calls)
+	}
+}
@@ -0,0 +1,114 @@
+package dynamicreplace
+
+import (
+	"sync"
+	"time"
+)
+
+// circuitState is the lifecycle of a single circuitBreaker.
+type circuitState int
+
+const (
+	circuitClosed circuitState = iota
+	circuitOpen
+	circuitHalfOpen
+)
+
+// circuitBreaker protects a single destination from being hammered once
+// it starts failing: it opens after threshold consecutive failures,
+// short-circuits every call while open, and after cooldown lets exactly
+// one trial request through (half-open) to decide whether to close again.
+type circuitBreaker struct {
+	mu                  sync.Mutex
+	state               circuitState
+	threshold           int
+	cooldown            time.Duration
+	consecutiveFailures int
+	openedAt            time.Time
+	trialInFlight       bool
+}
+
+func newCircuitBreaker(threshold int, cooldown time.Duration) *circuitBreaker {
+	return &circuitBreaker{threshold: threshold, cooldown: cooldown}
+}
+
+// allow reports whether a request may proceed, transitioning open ->
+// half-open once the cooldown has elapsed.
+func (cb *circuitBreaker) allow() bool {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	switch cb.state {
+	case circuitOpen:
+		if time.Since(cb.openedAt) < cb.cooldown {
+			return false
+		}
+		cb.state = circuitHalfOpen
+		cb.trialInFlight = true
+		return true
+	case circuitHalfOpen:
+		// Only the request that triggered the open -> half-open
+		// transition gets to probe the destination; everyone else is
+		// turned away until that trial's result is recorded, instead
+		// of all piling onto a destination that might still be down.
+		return false
+	default:
+		return true
+	}
+}
+
+func (cb *circuitBreaker) recordSuccess() {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	cb.consecutiveFailures = 0
+	cb.trialInFlight = false
+	cb.state = circuitClosed
+}
+
+func (cb *circuitBreaker) recordFailure() {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	cb.trialInFlight = false
+	if cb.state == circuitHalfOpen {
+		cb.state = circuitOpen
+		cb.openedAt = time.Now()
+		return
+	}
+
+	cb.consecutiveFailures++
+	if cb.consecutiveFailures >= cb.threshold {
+		cb.state = circuitOpen
+		cb.openedAt = time.Now()
+	}
+}
+
+// circuitBreakerRegistry hands out one circuitBreaker per destination
+// (keyed by URL), creating it lazily on first use.
+type circuitBreakerRegistry struct {
+	mu        sync.Mutex
+	breakers  map[string]*circuitBreaker
+	threshold int
+	cooldown  time.Duration
+}
+
+func newCircuitBreakerRegistry(threshold int, cooldown time.Duration) *circuitBreakerRegistry {
+	return &circuitBreakerRegistry{
+		breakers:  make(map[string]*circuitBreaker),
+		threshold: threshold,
+		cooldown:  cooldown,
+	}
+}
+
+func (r *circuitBreakerRegistry) get(destination string) *circuitBreaker {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	cb, ok := r.breakers[destination]
+	if !ok {
+		cb = newCircuitBreaker(r.threshold, r.cooldown)
+		r.breakers[destination] = cb
+	}
+	return cb
+}
@@ -0,0 +1,138 @@
+package dynamicreplace
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	uidSourceModeJSON   = "json"
+	uidSourceModeForm   = "form"
+	uidSourceModeXML    = "xml"
+	uidSourceModeHeader = "header"
+)
+
+// UIDSource configures how the uid used for enrichment lookups is
+// extracted from an incoming request. Mode selects the strategy:
+//
+//	"json"   (default) top-level "uid" field of a JSON body
+//	"form"   "uid" field of an application/x-www-form-urlencoded body
+//	"xml"    first <uid> element of an XML body
+//	"header" Header, JWTClaim, or PathRegex below, without reading the body
+type UIDSource struct {
+	Mode      string `json:"mode,omitempty"`
+	Header    string `json:"header,omitempty"`
+	JWTClaim  string `json:"jwtClaim,omitempty"`
+	PathRegex string `json:"pathRegex,omitempty"`
+}
+
+func normalizeUIDSourceMode(mode string) string {
+	if mode == "" {
+		return uidSourceModeJSON
+	}
+	return mode
+}
+
+// decodeUID extracts the uid from a fully-buffered request body per one
+// of the body-based UIDSource modes (everything but "header").
+func decodeUID(mode string, body []byte) (string, error) {
+	switch mode {
+	case uidSourceModeJSON:
+		var data map[string]interface{}
+		if err := json.Unmarshal(body, &data); err != nil {
+			return "", fmt.Errorf("invalid JSON body: %w", err)
+		}
+		uid, _ := data["uid"].(string)
+		return uid, nil
+	case uidSourceModeForm:
+		values, err := url.ParseQuery(string(body))
+		if err != nil {
+			return "", fmt.Errorf("invalid form body: %w", err)
+		}
+		return values.Get("uid"), nil
+	case uidSourceModeXML:
+		return decodeUIDFromXML(body)
+	default:
+		return "", fmt.Errorf("unsupported uidSource mode %q", mode)
+	}
+}
+
+// decodeUIDFromXML returns the text content of the first <uid> element
+// found anywhere in body, regardless of nesting depth.
+func decodeUIDFromXML(body []byte) (string, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(body))
+	for {
+		tok, err := decoder.Token()
+		if err == io.EOF {
+			return "", nil
+		}
+		if err != nil {
+			return "", fmt.Errorf("invalid XML body: %w", err)
+		}
+		start, ok := tok.(xml.StartElement)
+		if !ok || start.Name.Local != "uid" {
+			continue
+		}
+		var text string
+		if err := decoder.DecodeElement(&text, &start); err != nil {
+			return "", fmt.Errorf("invalid XML body: %w", err)
+		}
+		return text, nil
+	}
+}
+
+// extractUIDFromRequest extracts the uid for "header" mode, without
+// reading the request body, so the body can be streamed through
+// untouched. PathRegex is used if set; otherwise JWTClaim is read from
+// the token in the Header header; otherwise Header's value is the uid.
+// At least one of them must be set.
+func (d *DynamicReplacePlugin) extractUIDFromRequest(req *http.Request) (string, error) {
+	source := d.uidSource
+
+	switch {
+	case source.PathRegex != "":
+		match := d.uidPathRegex.FindStringSubmatch(req.URL.Path)
+		idx := d.uidPathRegex.SubexpIndex("uid")
+		if idx == -1 || idx >= len(match) {
+			return "", fmt.Errorf("uid not found in path %q", req.URL.Path)
+		}
+		return match[idx], nil
+	case source.JWTClaim != "":
+		return extractJWTClaim(req.Header.Get(source.Header), source.JWTClaim)
+	case source.Header != "":
+		return req.Header.Get(source.Header), nil
+	default:
+		return "", fmt.Errorf("uidSource: header, jwtClaim, or pathRegex must be set in header mode")
+	}
+}
+
+// extractJWTClaim pulls a claim out of an unverified JWT. This plugin
+// only needs the claim for routing the enrichment lookup; it relies on
+// an upstream auth middleware to have already verified the token.
+func extractJWTClaim(token, claim string) (string, error) {
+	token = strings.TrimPrefix(token, "Bearer ")
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return "", fmt.Errorf("malformed JWT")
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", fmt.Errorf("invalid JWT payload: %w", err)
+	}
+
+	var claims map[string]interface{}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return "", fmt.Errorf("invalid JWT claims: %w", err)
+	}
+
+	value, _ := claims[claim].(string)
+	return value, nil
+}
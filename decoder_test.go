@@ -0,0 +1,187 @@
+package dynamicreplace
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+)
+
+func TestDecodeUIDJSON(t *testing.T) {
+	uid, err := decodeUID(uidSourceModeJSON, []byte(`{"uid":"abc123"}`))
+	if err != nil {
+		t.Fatalf("decodeUID: %v", err)
+	}
+	if uid != "abc123" {
+		t.Errorf("uid = %q, want %q", uid, "abc123")
+	}
+}
+
+func TestDecodeUIDJSONInvalidBody(t *testing.T) {
+	if _, err := decodeUID(uidSourceModeJSON, []byte(`not json`)); err == nil {
+		t.Error("decodeUID() with invalid JSON: want error, got nil")
+	}
+}
+
+func TestDecodeUIDForm(t *testing.T) {
+	uid, err := decodeUID(uidSourceModeForm, []byte(`uid=abc123&other=x`))
+	if err != nil {
+		t.Fatalf("decodeUID: %v", err)
+	}
+	if uid != "abc123" {
+		t.Errorf("uid = %q, want %q", uid, "abc123")
+	}
+}
+
+func TestDecodeUIDXML(t *testing.T) {
+	uid, err := decodeUID(uidSourceModeXML, []byte(`<request><user><uid>abc123</uid></user></request>`))
+	if err != nil {
+		t.Fatalf("decodeUID: %v", err)
+	}
+	if uid != "abc123" {
+		t.Errorf("uid = %q, want %q", uid, "abc123")
+	}
+}
+
+func TestDecodeUIDXMLNoMatch(t *testing.T) {
+	uid, err := decodeUID(uidSourceModeXML, []byte(`<request><user><id>abc123</id></user></request>`))
+	if err != nil {
+		t.Fatalf("decodeUID: %v", err)
+	}
+	if uid != "" {
+		t.Errorf("uid = %q, want empty", uid)
+	}
+}
+
+func TestDecodeUIDXMLInvalidBody(t *testing.T) {
+	if _, err := decodeUID(uidSourceModeXML, []byte(`<unterminated`)); err == nil {
+		t.Error("decodeUID() with invalid XML: want error, got nil")
+	}
+}
+
+func TestDecodeUIDUnsupportedMode(t *testing.T) {
+	if _, err := decodeUID("yaml", []byte(`uid: abc123`)); err == nil {
+		t.Error("decodeUID() with unsupported mode: want error, got nil")
+	}
+}
+
+func TestNormalizeUIDSourceMode(t *testing.T) {
+	if got := normalizeUIDSourceMode(""); got != uidSourceModeJSON {
+		t.Errorf("normalizeUIDSourceMode(\"\") = %q, want %q", got, uidSourceModeJSON)
+	}
+	if got := normalizeUIDSourceMode(uidSourceModeXML); got != uidSourceModeXML {
+		t.Errorf("normalizeUIDSourceMode(%q) = %q, want unchanged", uidSourceModeXML, got)
+	}
+}
+
+func makeJWT(t *testing.T, claims map[string]interface{}) string {
+	t.Helper()
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`))
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatalf("json.Marshal: %v", err)
+	}
+	return header + "." + base64.RawURLEncoding.EncodeToString(payload) + ".sig"
+}
+
+func TestExtractJWTClaim(t *testing.T) {
+	token := "Bearer " + makeJWT(t, map[string]interface{}{"sub": "abc123"})
+
+	uid, err := extractJWTClaim(token, "sub")
+	if err != nil {
+		t.Fatalf("extractJWTClaim: %v", err)
+	}
+	if uid != "abc123" {
+		t.Errorf("uid = %q, want %q", uid, "abc123")
+	}
+}
+
+func TestExtractJWTClaimMalformed(t *testing.T) {
+	if _, err := extractJWTClaim("Bearer not.a.jwt.token", "sub"); err == nil {
+		t.Error("extractJWTClaim() with malformed token: want error, got nil")
+	}
+}
+
+func TestExtractJWTClaimMissingClaim(t *testing.T) {
+	token := makeJWT(t, map[string]interface{}{"other": "x"})
+
+	uid, err := extractJWTClaim(token, "sub")
+	if err != nil {
+		t.Fatalf("extractJWTClaim: %v", err)
+	}
+	if uid != "" {
+		t.Errorf("uid = %q, want empty", uid)
+	}
+}
+
+func TestExtractUIDFromRequestHeader(t *testing.T) {
+	d := &DynamicReplacePlugin{uidSource: UIDSource{Mode: uidSourceModeHeader, Header: "X-UID"}}
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("X-UID", "abc123")
+
+	uid, err := d.extractUIDFromRequest(req)
+	if err != nil {
+		t.Fatalf("extractUIDFromRequest: %v", err)
+	}
+	if uid != "abc123" {
+		t.Errorf("uid = %q, want %q", uid, "abc123")
+	}
+}
+
+func TestExtractUIDFromRequestJWTClaim(t *testing.T) {
+	d := &DynamicReplacePlugin{uidSource: UIDSource{Mode: uidSourceModeHeader, Header: "Authorization", JWTClaim: "sub"}}
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Authorization", "Bearer "+makeJWT(t, map[string]interface{}{"sub": "abc123"}))
+
+	uid, err := d.extractUIDFromRequest(req)
+	if err != nil {
+		t.Fatalf("extractUIDFromRequest: %v", err)
+	}
+	if uid != "abc123" {
+		t.Errorf("uid = %q, want %q", uid, "abc123")
+	}
+}
+
+func TestExtractUIDFromRequestPathRegex(t *testing.T) {
+	d := &DynamicReplacePlugin{
+		uidSource:    UIDSource{Mode: uidSourceModeHeader, PathRegex: `/users/(?P<uid>[^/]+)`},
+		uidPathRegex: regexp.MustCompile(`/users/(?P<uid>[^/]+)`),
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/users/abc123", nil)
+
+	uid, err := d.extractUIDFromRequest(req)
+	if err != nil {
+		t.Fatalf("extractUIDFromRequest: %v", err)
+	}
+	if uid != "abc123" {
+		t.Errorf("uid = %q, want %q", uid, "abc123")
+	}
+}
+
+func TestExtractUIDFromRequestPathRegexNoMatch(t *testing.T) {
+	d := &DynamicReplacePlugin{
+		uidSource:    UIDSource{Mode: uidSourceModeHeader, PathRegex: `/users/(?P<uid>[^/]+)`},
+		uidPathRegex: regexp.MustCompile(`/users/(?P<uid>[^/]+)`),
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/accounts/abc123", nil)
+
+	if _, err := d.extractUIDFromRequest(req); err == nil {
+		t.Error("extractUIDFromRequest() with no path match: want error, got nil")
+	}
+}
+
+func TestExtractUIDFromRequestNoSourceConfigured(t *testing.T) {
+	d := &DynamicReplacePlugin{uidSource: UIDSource{Mode: uidSourceModeHeader}}
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+
+	if _, err := d.extractUIDFromRequest(req); err == nil {
+		t.Error("extractUIDFromRequest() with no header/jwtClaim/pathRegex set: want error, got nil")
+	}
+}
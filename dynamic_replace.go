@@ -1,22 +1,53 @@
 package dynamicreplace
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
-	"strings"
-
-	"github.com/traefik/traefik/v2/pkg/config/dynamic"
-	"github.com/traefik/traefik/v2/pkg/plugins"
+	"net/http/httputil"
+	"regexp"
+	"time"
 )
 
 // Config defines the plugin configuration.
 type Config struct {
-	APIURL         string   `json:"apiURL,omitempty"`
+	APIURL          string   `json:"apiURL,omitempty"`
 	ReplaceableKeys []string `json:"replaceableKeys,omitempty"`
-	DestinationURL string   `json:"destinationURL,omitempty"`
+
+	// DestinationURL is optional. When set, requests are reverse-proxied
+	// there. When empty, the (possibly rewritten) request is forwarded
+	// to the next handler in the Traefik middleware chain instead.
+	DestinationURL string `json:"destinationURL,omitempty"`
+
+	// CacheType selects the Cache backend: "memory" (default) or "redis".
+	CacheType       string `json:"cacheType,omitempty"`
+	CacheTTL        string `json:"cacheTTL,omitempty"`
+	CacheMaxEntries int    `json:"cacheMaxEntries,omitempty"`
+	RedisURL        string `json:"redisURL,omitempty"`
+
+	// Retry policy applied to upstream calls, mirroring cenkalti/backoff.
+	MaxRetries          int     `json:"maxRetries,omitempty"`
+	InitialInterval     string  `json:"initialInterval,omitempty"`
+	MaxInterval         string  `json:"maxInterval,omitempty"`
+	Multiplier          float64 `json:"multiplier,omitempty"`
+	RandomizationFactor float64 `json:"randomizationFactor,omitempty"`
+
+	// Per-destination circuit breaker.
+	CircuitBreakerThreshold int    `json:"circuitBreakerThreshold,omitempty"`
+	CircuitBreakerCooldown  string `json:"circuitBreakerCooldown,omitempty"`
+
+	// UIDSource selects how the uid is extracted from the request.
+	UIDSource UIDSource `json:"uidSource,omitempty"`
+
+	// Rules scopes rewriting to specific routes, each with its own
+	// enrichment source and allow-list. When set, requests matching no
+	// rule pass through untouched to the next handler.
+	Rules []RuleConfig `json:"rules,omitempty"`
 }
 
 // CreateConfig initializes the plugin configuration.
@@ -26,30 +57,159 @@ func CreateConfig() *Config {
 
 // DynamicReplacePlugin represents the plugin.
 type DynamicReplacePlugin struct {
-	next             http.Handler
-	name             string
-	apiURL           string
-	replaceableKeys  []string
-	destinationURL   string
+	next            http.Handler
+	name            string
+	apiURL          string
+	replaceableKeys []string
+	allowedKeys     map[string]bool
+	destinationURL  string
+	proxy           *httputil.ReverseProxy
+
+	cache      Cache
+	cacheTTL   time.Duration
+	fetchGroup callGroup
+
+	retryPolicy *retryPolicy
+	breakers    *circuitBreakerRegistry
+
+	uidSource    UIDSource
+	uidPathRegex *regexp.Regexp
+
+	rules []compiledRule
 }
 
 // New creates a new DynamicReplacePlugin.
 func New(ctx context.Context, next http.Handler, config *Config, name string) (http.Handler, error) {
-	if config.APIURL == "" || config.DestinationURL == "" || len(config.ReplaceableKeys) == 0 {
+	hasGlobalConfig := config.APIURL != "" && len(config.ReplaceableKeys) > 0
+	if !hasGlobalConfig && len(config.Rules) == 0 {
 		return nil, fmt.Errorf("invalid configuration")
 	}
 
+	allowedKeys := make(map[string]bool, len(config.ReplaceableKeys))
+	for _, key := range config.ReplaceableKeys {
+		allowedKeys[key] = true
+	}
+
+	cacheTTL := 30 * time.Second
+	if config.CacheTTL != "" {
+		parsed, err := time.ParseDuration(config.CacheTTL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cacheTTL: %w", err)
+		}
+		cacheTTL = parsed
+	}
+
+	cache, err := newCache(config)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cache configuration: %w", err)
+	}
+
+	retryPolicy, err := newRetryPolicy(config)
+	if err != nil {
+		return nil, fmt.Errorf("invalid retry configuration: %w", err)
+	}
+
+	breakerThreshold := config.CircuitBreakerThreshold
+	if breakerThreshold <= 0 {
+		breakerThreshold = 5
+	}
+	breakerCooldown := 30 * time.Second
+	if config.CircuitBreakerCooldown != "" {
+		d, err := time.ParseDuration(config.CircuitBreakerCooldown)
+		if err != nil {
+			return nil, fmt.Errorf("invalid circuitBreakerCooldown: %w", err)
+		}
+		breakerCooldown = d
+	}
+
+	uidSource := config.UIDSource
+	uidSource.Mode = normalizeUIDSourceMode(uidSource.Mode)
+
+	var uidPathRegex *regexp.Regexp
+	if uidSource.Mode == uidSourceModeHeader && uidSource.PathRegex != "" {
+		compiled, err := regexp.Compile(uidSource.PathRegex)
+		if err != nil {
+			return nil, fmt.Errorf("invalid uidSource.pathRegex: %w", err)
+		}
+		uidPathRegex = compiled
+	}
+
+	rules, err := compileRules(config.Rules)
+	if err != nil {
+		return nil, err
+	}
+
+	breakers := newCircuitBreakerRegistry(breakerThreshold, breakerCooldown)
+
+	var proxy *httputil.ReverseProxy
+	if config.DestinationURL != "" {
+		proxy, err = newReverseProxy(config.DestinationURL, breakers, retryPolicy)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	return &DynamicReplacePlugin{
-		next:             next,
-		name:             name,
-		apiURL:           config.APIURL,
-		replaceableKeys:  config.ReplaceableKeys,
-		destinationURL:   config.DestinationURL,
+		next:            next,
+		name:            name,
+		apiURL:          config.APIURL,
+		replaceableKeys: config.ReplaceableKeys,
+		allowedKeys:     allowedKeys,
+		destinationURL:  config.DestinationURL,
+		proxy:           proxy,
+		cache:           cache,
+		cacheTTL:        cacheTTL,
+		retryPolicy:     retryPolicy,
+		breakers:        breakers,
+		uidSource:       uidSource,
+		uidPathRegex:    uidPathRegex,
+		rules:           rules,
 	}, nil
 }
 
-// ServeHTTP processes the HTTP request.
+// ServeHTTP processes the HTTP request. In "header" UIDSource mode the
+// body is never buffered: the uid comes from a header/JWT claim/path
+// parameter and the body is streamed straight through to the
+// destination, which keeps memory bounded for large or non-rewritable
+// payloads (multipart, gRPC-Web, ...). Every other mode buffers the
+// body to both extract the uid and render it as a template.
 func (d *DynamicReplacePlugin) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	apiURL, allowedKeys, uidJSONPath := d.apiURL, d.allowedKeys, ""
+
+	if len(d.rules) > 0 {
+		rule := matchRule(d.rules, req)
+		if rule == nil {
+			d.next.ServeHTTP(rw, req)
+			return
+		}
+		apiURL, allowedKeys, uidJSONPath = rule.apiURL, rule.allowedKeys, rule.uidJSONPath
+	}
+
+	if d.uidSource.Mode == uidSourceModeHeader {
+		uid, err := d.extractUIDFromRequest(req)
+		if err != nil || uid == "" {
+			http.Error(rw, "uid not found in request", http.StatusBadRequest)
+			return
+		}
+
+		fetchedData, err := d.fetchDataFromAPI(apiURL, uid)
+		if err != nil {
+			writeFetchError(rw, err)
+			return
+		}
+
+		// The body is left untouched and streamed straight through; the
+		// fetched fields are surfaced as headers instead of being
+		// templated into it.
+		for key := range allowedKeys {
+			if value, ok := fetchedData[key]; ok {
+				req.Header.Set("X-Replace-"+key, stringify(value))
+			}
+		}
+		d.forward(rw, req)
+		return
+	}
+
 	// Read request body
 	body, err := ioutil.ReadAll(req.Body)
 	if err != nil {
@@ -57,79 +217,135 @@ func (d *DynamicReplacePlugin) ServeHTTP(rw http.ResponseWriter, req *http.Reque
 		return
 	}
 
-	// Extract uid from the request body
-	var requestData map[string]interface{}
-	if err := json.Unmarshal(body, &requestData); err != nil {
-		http.Error(rw, "invalid JSON", http.StatusBadRequest)
-		return
+	// Extract uid: a rule's UIDJSONPath takes precedence over the
+	// plugin-wide UIDSource decoding.
+	var uid string
+	if uidJSONPath != "" {
+		var data map[string]interface{}
+		if err := json.Unmarshal(body, &data); err != nil {
+			http.Error(rw, "invalid JSON body", http.StatusBadRequest)
+			return
+		}
+		uid, _ = evalJSONPath(uidJSONPath, data)
+	} else {
+		uid, err = decodeUID(d.uidSource.Mode, body)
+		if err != nil {
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
 	}
-
-	uid, ok := requestData["uid"].(string)
-	if !ok || uid == "" {
+	if uid == "" {
 		http.Error(rw, "uid not found in request", http.StatusBadRequest)
 		return
 	}
 
 	// Fetch additional data using the uid
-	fetchedData, err := d.fetchDataFromAPI(uid)
+	fetchedData, err := d.fetchDataFromAPI(apiURL, uid)
 	if err != nil {
-		http.Error(rw, "could not fetch data from API", http.StatusInternalServerError)
+		writeFetchError(rw, err)
 		return
 	}
 
-	// Replace placeholders in the original body
-	updatedBody := string(body)
-	for _, key := range d.replaceableKeys {
-		if value, exists := fetchedData[key]; exists {
-			updatedBody = strings.ReplaceAll(updatedBody, fmt.Sprintf("{{%s}}", key), value)
-		}
+	// Compile (or reuse a cached compile of) the request body as a
+	// template, then render it against the fetched data in one pass.
+	tmpl, err := globalTemplateCache.getOrCompile(body)
+	if err != nil {
+		http.Error(rw, "invalid template", http.StatusBadRequest)
+		return
 	}
 
-	// Send the updated request to the destination URL
-	d.sendToDestination(rw, updatedBody)
+	var buf bytes.Buffer
+	tmpl.Render(&buf, fetchedData, allowedKeys)
+	rendered := buf.Bytes()
+
+	req.Body = ioutil.NopCloser(bytes.NewReader(rendered))
+	req.ContentLength = int64(len(rendered))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(rendered)), nil
+	}
+
+	d.forward(rw, req)
 }
 
-// fetchDataFromAPI fetches data from the configured API based on the uid.
-func (d *DynamicReplacePlugin) fetchDataFromAPI(uid string) (map[string]string, error) {
-	apiURL := fmt.Sprintf("%s?uid=%s", d.apiURL, uid)
+// forward sends req onward: to the configured destination (reverse
+// proxied, with method, headers, query string, trailers, and status
+// code all preserved, except that a 5xx or 429 still returned after the
+// last retry becomes a 502) when DestinationURL is set, or to the next
+// handler in the Traefik middleware chain otherwise.
+func (d *DynamicReplacePlugin) forward(rw http.ResponseWriter, req *http.Request) {
+	if d.proxy != nil {
+		d.proxy.ServeHTTP(rw, req)
+		return
+	}
+	d.next.ServeHTTP(rw, req)
+}
 
-	resp, err := http.Get(apiURL)
-	if err != nil {
-		return nil, err
+// writeFetchError maps a fetchDataFromAPI error to an HTTP response: 503
+// when the enrichment API's circuit breaker is open (the call was
+// short-circuited without being attempted), matching how the destination
+// proxy's ErrorHandler treats the same condition, or a generic 500
+// otherwise.
+func writeFetchError(rw http.ResponseWriter, err error) {
+	if errors.Is(err, errCircuitOpen) {
+		http.Error(rw, "enrichment API unavailable", http.StatusServiceUnavailable)
+		return
 	}
-	defer resp.Body.Close()
+	http.Error(rw, "could not fetch data from API", http.StatusInternalServerError)
+}
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("API request failed with status: %s", resp.Status)
+// fetchDataFromAPI returns enrichment data for uid from apiURL,
+// preferring the cache and coalescing concurrent requests for the same
+// (apiURL, uid) pair into a single upstream call.
+func (d *DynamicReplacePlugin) fetchDataFromAPI(apiURL, uid string) (map[string]interface{}, error) {
+	ctx := context.Background()
+	cacheKey := apiURL + "|" + uid
+
+	if cached, ok, err := d.cache.Get(ctx, cacheKey); err == nil && ok {
+		cacheHits.Inc()
+		return cached, nil
 	}
+	cacheMisses.Inc()
 
-	var responseData map[string]string
-	if err := json.NewDecoder(resp.Body).Decode(&responseData); err != nil {
+	result, err := d.fetchGroup.Do(cacheKey, func() (interface{}, error) {
+		data, err := d.fetchDataFromAPIUncached(apiURL, uid)
+		if err != nil {
+			return nil, err
+		}
+		if cacheErr := d.cache.Set(ctx, cacheKey, data, d.cacheTTL); cacheErr != nil {
+			return nil, cacheErr
+		}
+		return data, nil
+	})
+	if err != nil {
 		return nil, err
 	}
-
-	return responseData, nil
+	return result.(map[string]interface{}), nil
 }
 
-// sendToDestination forwards the modified request to the destination URL.
-func (d *DynamicReplacePlugin) sendToDestination(rw http.ResponseWriter, updatedBody string) {
-	client := &http.Client{}
-	req, err := http.NewRequest("POST", d.destinationURL, strings.NewReader(updatedBody))
+// fetchDataFromAPIUncached issues the actual HTTP GET to the enrichment
+// API; callers are expected to have already checked the cache. Network
+// errors and 5xx/429 responses are retried with backoff, and the
+// destination's circuit breaker short-circuits the call while open.
+func (d *DynamicReplacePlugin) fetchDataFromAPIUncached(apiURL, uid string) (map[string]interface{}, error) {
+	requestURL := fmt.Sprintf("%s?uid=%s", apiURL, uid)
+	breaker := d.breakers.get(apiURL)
+
+	resp, err := withResilience(breaker, d.retryPolicy, func() (*http.Response, error) {
+		return http.Get(requestURL)
+	})
 	if err != nil {
-		http.Error(rw, "could not create request to destination", http.StatusInternalServerError)
-		return
+		return nil, err
 	}
+	defer resp.Body.Close()
 
-	req.Header.Set("Content-Type", "application/json")
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("API request failed with status: %s", resp.Status)
+	}
 
-	resp, err := client.Do(req)
-	if err != nil {
-		http.Error(rw, "could not send request to destination", http.StatusInternalServerError)
-		return
+	var responseData map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&responseData); err != nil {
+		return nil, err
 	}
-	defer resp.Body.Close()
 
-	rw.WriteHeader(resp.StatusCode)
-	_, _ = rw.Write([]byte(fmt.Sprintf("Request sent to destination with status: %s", resp.Status)))
+	return responseData, nil
 }
-
@@ -0,0 +1,157 @@
+package dynamicreplace
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func newTestPlugin(t *testing.T, config *Config, next http.Handler) *DynamicReplacePlugin {
+	t.Helper()
+	if next == nil {
+		next = http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			rw.WriteHeader(http.StatusOK)
+			io.Copy(rw, req.Body)
+		})
+	}
+	handler, err := New(context.Background(), next, config, "test")
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	return handler.(*DynamicReplacePlugin)
+}
+
+func TestServeHTTPBodyModeRendersTemplate(t *testing.T) {
+	enrichment := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Content-Type", "application/json")
+		rw.Write([]byte(`{"name":"ada","email":"ada@example.com"}`))
+	}))
+	defer enrichment.Close()
+
+	plugin := newTestPlugin(t, &Config{
+		APIURL:          enrichment.URL,
+		ReplaceableKeys: []string{"name"},
+	}, nil)
+
+	body := `{"uid":"u1","greeting":"hello {{name}}, your email on file is {{email}}"}`
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	rw := httptest.NewRecorder()
+
+	plugin.ServeHTTP(rw, req)
+
+	if rw.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rw.Code)
+	}
+	got := rw.Body.String()
+	if !strings.Contains(got, "hello ada") {
+		t.Errorf("body = %q, want allow-listed field \"name\" rendered", got)
+	}
+	if !strings.Contains(got, "{{email}}") {
+		t.Errorf("body = %q, want non-allow-listed field \"email\" left as the raw tag", got)
+	}
+}
+
+func TestServeHTTPHeaderModeStreamsBodyAndSetsHeaders(t *testing.T) {
+	enrichment := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Content-Type", "application/json")
+		rw.Write([]byte(`{"plan":"pro"}`))
+	}))
+	defer enrichment.Close()
+
+	var forwardedHeader string
+	var forwardedBody string
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		forwardedHeader = req.Header.Get("X-Replace-plan")
+		b, _ := io.ReadAll(req.Body)
+		forwardedBody = string(b)
+		rw.WriteHeader(http.StatusOK)
+	})
+
+	plugin := newTestPlugin(t, &Config{
+		APIURL:          enrichment.URL,
+		ReplaceableKeys: []string{"plan"},
+		UIDSource:       UIDSource{Mode: uidSourceModeHeader, Header: "X-UID"},
+	}, next)
+
+	const rawBody = "this body is streamed through untouched"
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(rawBody))
+	req.Header.Set("X-UID", "u1")
+	rw := httptest.NewRecorder()
+
+	plugin.ServeHTTP(rw, req)
+
+	if rw.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rw.Code)
+	}
+	if forwardedHeader != "pro" {
+		t.Errorf("X-Replace-plan forwarded as %q, want %q", forwardedHeader, "pro")
+	}
+	if forwardedBody != rawBody {
+		t.Errorf("forwarded body = %q, want untouched %q", forwardedBody, rawBody)
+	}
+}
+
+func TestServeHTTPNoMatchingRulePassesThrough(t *testing.T) {
+	var called bool
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		called = true
+		rw.WriteHeader(http.StatusOK)
+	})
+
+	plugin := newTestPlugin(t, &Config{
+		Rules: []RuleConfig{
+			{PathRegex: `^/users/`, APIURL: "http://enrich.example", ReplaceableKeys: []string{"name"}},
+		},
+	}, next)
+
+	req := httptest.NewRequest(http.MethodGet, "/orders/1", nil)
+	rw := httptest.NewRecorder()
+
+	plugin.ServeHTTP(rw, req)
+
+	if !called {
+		t.Error("ServeHTTP() with no matching rule did not forward to next handler")
+	}
+	if rw.Code != http.StatusOK {
+		t.Errorf("status = %d, want 200", rw.Code)
+	}
+}
+
+func TestServeHTTPMissingUIDReturnsBadRequest(t *testing.T) {
+	plugin := newTestPlugin(t, &Config{
+		APIURL:          "http://enrich.example",
+		ReplaceableKeys: []string{"name"},
+	}, nil)
+
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"no_uid_here":true}`))
+	rw := httptest.NewRecorder()
+
+	plugin.ServeHTTP(rw, req)
+
+	if rw.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want 400", rw.Code)
+	}
+}
+
+func TestServeHTTPCircuitOpenReturns503(t *testing.T) {
+	plugin := newTestPlugin(t, &Config{
+		APIURL:                  "http://example.invalid",
+		ReplaceableKeys:         []string{"name"},
+		CircuitBreakerThreshold: 1,
+		CircuitBreakerCooldown:  "1h",
+		MaxRetries:              0,
+	}, nil)
+	plugin.breakers.get("http://example.invalid").recordFailure()
+
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"uid":"u1"}`))
+	rw := httptest.NewRecorder()
+
+	plugin.ServeHTTP(rw, req)
+
+	if rw.Code != http.StatusServiceUnavailable {
+		t.Errorf("status = %d, want 503", rw.Code)
+	}
+}
@@ -0,0 +1,54 @@
+package dynamicreplace
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+)
+
+// newReverseProxy builds an httputil.ReverseProxy targeting
+// destinationURL. It preserves the incoming request's method, headers,
+// query string, and trailers, streams the upstream response back to the
+// client, and passes the upstream status code through verbatim.
+// Retries and the circuit breaker for this destination are applied via
+// the proxy's Transport. A 5xx or 429 response that is still returned
+// after the last retry is not passed through: the client gets a 502.
+func newReverseProxy(destinationURL string, breakers *circuitBreakerRegistry, policy *retryPolicy) (*httputil.ReverseProxy, error) {
+	target, err := url.Parse(destinationURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid destinationURL: %w", err)
+	}
+
+	proxy := httputil.NewSingleHostReverseProxy(target)
+
+	baseDirector := proxy.Director
+	proxy.Director = func(req *http.Request) {
+		baseDirector(req)
+		req.Header.Set("X-Forwarded-Host", req.Host)
+		req.Header.Set("X-Forwarded-Proto", schemeOf(req))
+	}
+
+	proxy.Transport = &resilientTransport{
+		base:    http.DefaultTransport,
+		breaker: breakers.get(destinationURL),
+		policy:  policy,
+	}
+
+	proxy.ErrorHandler = func(rw http.ResponseWriter, _ *http.Request, err error) {
+		if err == errCircuitOpen {
+			http.Error(rw, "destination unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		http.Error(rw, "could not send request to destination", http.StatusBadGateway)
+	}
+
+	return proxy, nil
+}
+
+func schemeOf(req *http.Request) string {
+	if req.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
@@ -0,0 +1,98 @@
+package dynamicreplace
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewReverseProxyForwardsMethodHeadersQueryAndStatus(t *testing.T) {
+	var gotMethod, gotQuery, gotForwardedHost, gotForwardedProto string
+	backend := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		gotMethod = req.Method
+		gotQuery = req.URL.RawQuery
+		gotForwardedHost = req.Header.Get("X-Forwarded-Host")
+		gotForwardedProto = req.Header.Get("X-Forwarded-Proto")
+		rw.Header().Set("X-Upstream", "yes")
+		rw.WriteHeader(http.StatusCreated)
+		rw.Write([]byte("ok"))
+	}))
+	defer backend.Close()
+
+	breakers := newCircuitBreakerRegistry(5, time.Second)
+	policy := newTestRetryPolicy(0)
+
+	proxy, err := newReverseProxy(backend.URL, breakers, policy)
+	if err != nil {
+		t.Fatalf("newReverseProxy: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/path?x=1", strings.NewReader("body"))
+	req.Host = "client.example"
+	rw := httptest.NewRecorder()
+
+	proxy.ServeHTTP(rw, req)
+
+	if gotMethod != http.MethodPost {
+		t.Errorf("backend saw method %q, want POST", gotMethod)
+	}
+	if gotQuery != "x=1" {
+		t.Errorf("backend saw query %q, want %q", gotQuery, "x=1")
+	}
+	if gotForwardedHost != "client.example" {
+		t.Errorf("X-Forwarded-Host = %q, want %q", gotForwardedHost, "client.example")
+	}
+	if gotForwardedProto != "http" {
+		t.Errorf("X-Forwarded-Proto = %q, want %q", gotForwardedProto, "http")
+	}
+	if rw.Code != http.StatusCreated {
+		t.Errorf("status = %d, want %d", rw.Code, http.StatusCreated)
+	}
+	if rw.Header().Get("X-Upstream") != "yes" {
+		t.Error("response header from upstream was not passed through")
+	}
+	if rw.Body.String() != "ok" {
+		t.Errorf("body = %q, want %q", rw.Body.String(), "ok")
+	}
+}
+
+func TestNewReverseProxyReturns503WhenCircuitOpen(t *testing.T) {
+	breakers := newCircuitBreakerRegistry(1, time.Hour)
+	breakers.get("http://example.invalid").recordFailure()
+
+	proxy, err := newReverseProxy("http://example.invalid", breakers, newTestRetryPolicy(0))
+	if err != nil {
+		t.Fatalf("newReverseProxy: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	rw := httptest.NewRecorder()
+
+	proxy.ServeHTTP(rw, req)
+
+	if rw.Code != http.StatusServiceUnavailable {
+		t.Errorf("status = %d, want %d", rw.Code, http.StatusServiceUnavailable)
+	}
+}
+
+func TestNewReverseProxyInvalidDestinationURL(t *testing.T) {
+	breakers := newCircuitBreakerRegistry(5, time.Second)
+	if _, err := newReverseProxy("://not-a-url", breakers, newTestRetryPolicy(0)); err == nil {
+		t.Error("newReverseProxy() with invalid destinationURL: want error, got nil")
+	}
+}
+
+func TestSchemeOf(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	if got := schemeOf(req); got != "http" {
+		t.Errorf("schemeOf() = %q, want %q", got, "http")
+	}
+
+	req.TLS = &tls.ConnectionState{}
+	if got := schemeOf(req); got != "https" {
+		t.Errorf("schemeOf() with TLS = %q, want %q", got, "https")
+	}
+}
@@ -0,0 +1,175 @@
+package dynamicreplace
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+)
+
+// errCircuitOpen is returned when a destination's circuit breaker is
+// open and the request was short-circuited without being attempted.
+var errCircuitOpen = errors.New("dynamicreplace: circuit breaker open")
+
+// errNonReplayableBody is returned by a retry attempt whose request
+// body was already consumed and cannot be replayed (no GetBody).
+var errNonReplayableBody = errors.New("dynamicreplace: request body is not replayable")
+
+// retryPolicy holds the exponential-backoff knobs, mirroring the
+// parameters exposed by cenkalti/backoff.
+type retryPolicy struct {
+	maxRetries          int
+	initialInterval     time.Duration
+	maxInterval         time.Duration
+	multiplier          float64
+	randomizationFactor float64
+}
+
+func newRetryPolicy(config *Config) (*retryPolicy, error) {
+	p := &retryPolicy{
+		maxRetries:          3,
+		initialInterval:     500 * time.Millisecond,
+		maxInterval:         60 * time.Second,
+		multiplier:          1.5,
+		randomizationFactor: 0.5,
+	}
+
+	if config.MaxRetries > 0 {
+		p.maxRetries = config.MaxRetries
+	}
+	if config.InitialInterval != "" {
+		d, err := time.ParseDuration(config.InitialInterval)
+		if err != nil {
+			return nil, fmt.Errorf("invalid initialInterval: %w", err)
+		}
+		p.initialInterval = d
+	}
+	if config.MaxInterval != "" {
+		d, err := time.ParseDuration(config.MaxInterval)
+		if err != nil {
+			return nil, fmt.Errorf("invalid maxInterval: %w", err)
+		}
+		p.maxInterval = d
+	}
+	if config.Multiplier > 0 {
+		p.multiplier = config.Multiplier
+	}
+	if config.RandomizationFactor > 0 {
+		p.randomizationFactor = config.RandomizationFactor
+	}
+
+	return p, nil
+}
+
+func (p *retryPolicy) newExponentialBackOff() *backoff.ExponentialBackOff {
+	b := backoff.NewExponentialBackOff()
+	b.InitialInterval = p.initialInterval
+	b.MaxInterval = p.maxInterval
+	b.Multiplier = p.multiplier
+	b.RandomizationFactor = p.randomizationFactor
+	b.MaxElapsedTime = 0 // bounded by maxRetries, not elapsed time
+	b.Reset()
+	return b
+}
+
+// isRetryableStatus reports whether a response status code should be
+// retried: any 5xx, or 429 (rate limited).
+func isRetryableStatus(statusCode int) bool {
+	return statusCode == http.StatusTooManyRequests || statusCode >= 500
+}
+
+// parseRetryAfter parses an RFC 7231 Retry-After header's delay-seconds
+// form. It returns 0 if the header is absent or not a plain integer.
+func parseRetryAfter(header string) time.Duration {
+	if header == "" {
+		return 0
+	}
+	seconds, err := strconv.Atoi(header)
+	if err != nil || seconds < 0 {
+		return 0
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// withResilience runs do (an HTTP call) behind breaker's circuit and
+// retries network errors and 5xx/429 responses with exponential
+// backoff and jitter, honoring a Retry-After header when present.
+func withResilience(breaker *circuitBreaker, policy *retryPolicy, do func() (*http.Response, error)) (*http.Response, error) {
+	if !breaker.allow() {
+		return nil, errCircuitOpen
+	}
+
+	b := policy.newExponentialBackOff()
+
+	var lastErr error
+	for attempt := 0; attempt <= policy.maxRetries; attempt++ {
+		resp, err := do()
+		if err == nil && !isRetryableStatus(resp.StatusCode) {
+			breaker.recordSuccess()
+			return resp, nil
+		}
+
+		if err != nil {
+			lastErr = err
+		} else {
+			lastErr = fmt.Errorf("upstream returned %s", resp.Status)
+		}
+
+		if errors.Is(lastErr, errNonReplayableBody) {
+			break
+		}
+
+		if attempt == policy.maxRetries {
+			if resp != nil {
+				resp.Body.Close()
+			}
+			break
+		}
+
+		wait := b.NextBackOff()
+		if resp != nil {
+			if ra := parseRetryAfter(resp.Header.Get("Retry-After")); ra > 0 {
+				wait = ra
+			}
+			resp.Body.Close()
+		}
+		if wait == backoff.Stop {
+			break
+		}
+		time.Sleep(wait)
+	}
+
+	breaker.recordFailure()
+	return nil, lastErr
+}
+
+// resilientTransport is an http.RoundTripper that applies withResilience
+// to every request passing through it, replaying the request body via
+// req.GetBody on each retry. Requests whose body can't be replayed
+// (GetBody is nil, e.g. a streamed passthrough body) are attempted once.
+type resilientTransport struct {
+	base    http.RoundTripper
+	breaker *circuitBreaker
+	policy  *retryPolicy
+}
+
+func (t *resilientTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	attempted := false
+	return withResilience(t.breaker, t.policy, func() (*http.Response, error) {
+		if attempted {
+			if req.GetBody == nil {
+				return nil, errNonReplayableBody
+			}
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req.Body = body
+		}
+		attempted = true
+		return t.base.RoundTrip(req)
+	})
+}
@@ -0,0 +1,220 @@
+package dynamicreplace
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func newTestRetryPolicy(maxRetries int) *retryPolicy {
+	return &retryPolicy{
+		maxRetries:          maxRetries,
+		initialInterval:     time.Millisecond,
+		maxInterval:         5 * time.Millisecond,
+		multiplier:          1.5,
+		randomizationFactor: 0,
+	}
+}
+
+func TestIsRetryableStatus(t *testing.T) {
+	cases := map[int]bool{
+		http.StatusOK:                  false,
+		http.StatusBadRequest:          false,
+		http.StatusTooManyRequests:     true,
+		http.StatusInternalServerError: true,
+		http.StatusBadGateway:          true,
+	}
+	for status, want := range cases {
+		if got := isRetryableStatus(status); got != want {
+			t.Errorf("isRetryableStatus(%d) = %v, want %v", status, got, want)
+		}
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	cases := map[string]time.Duration{
+		"":     0,
+		"5":    5 * time.Second,
+		"0":    0,
+		"-1":   0,
+		"soon": 0,
+	}
+	for header, want := range cases {
+		if got := parseRetryAfter(header); got != want {
+			t.Errorf("parseRetryAfter(%q) = %v, want %v", header, got, want)
+		}
+	}
+}
+
+func TestWithResilienceRetriesOnRetryableStatus(t *testing.T) {
+	attempts := 0
+	do := func() (*http.Response, error) {
+		attempts++
+		if attempts < 3 {
+			return &http.Response{StatusCode: http.StatusServiceUnavailable, Body: http.NoBody, Header: http.Header{}}, nil
+		}
+		return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody, Header: http.Header{}}, nil
+	}
+
+	breaker := newCircuitBreaker(5, time.Second)
+	resp, err := withResilience(breaker, newTestRetryPolicy(3), do)
+	if err != nil {
+		t.Fatalf("withResilience: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("final status = %d, want 200", resp.StatusCode)
+	}
+	if attempts != 3 {
+		t.Errorf("attempts = %d, want 3", attempts)
+	}
+}
+
+func TestWithResilienceGivesUpAfterMaxRetries(t *testing.T) {
+	attempts := 0
+	do := func() (*http.Response, error) {
+		attempts++
+		return &http.Response{StatusCode: http.StatusInternalServerError, Body: http.NoBody, Header: http.Header{}}, nil
+	}
+
+	breaker := newCircuitBreaker(100, time.Second)
+	_, err := withResilience(breaker, newTestRetryPolicy(2), do)
+	if err == nil {
+		t.Fatal("withResilience: want error after exhausting retries, got nil")
+	}
+	if attempts != 3 {
+		t.Errorf("attempts = %d, want 3 (1 initial + 2 retries)", attempts)
+	}
+}
+
+func TestWithResilienceShortCircuitsWhenBreakerOpen(t *testing.T) {
+	breaker := newCircuitBreaker(1, time.Hour)
+	breaker.recordFailure()
+
+	called := false
+	_, err := withResilience(breaker, newTestRetryPolicy(3), func() (*http.Response, error) {
+		called = true
+		return nil, errors.New("should not be called")
+	})
+
+	if !errors.Is(err, errCircuitOpen) {
+		t.Errorf("err = %v, want errCircuitOpen", err)
+	}
+	if called {
+		t.Error("withResilience called do() while the breaker was open")
+	}
+}
+
+func TestCircuitBreakerOpensAfterThreshold(t *testing.T) {
+	cb := newCircuitBreaker(2, time.Hour)
+
+	cb.recordFailure()
+	if !cb.allow() {
+		t.Fatal("breaker opened before reaching threshold")
+	}
+
+	cb.recordFailure()
+	if cb.allow() {
+		t.Fatal("breaker did not open at threshold")
+	}
+}
+
+func TestCircuitBreakerHalfOpenAfterCooldown(t *testing.T) {
+	cb := newCircuitBreaker(1, time.Millisecond)
+	cb.recordFailure()
+
+	if cb.allow() {
+		t.Fatal("breaker allowed a request before cooldown elapsed")
+	}
+
+	time.Sleep(5 * time.Millisecond)
+
+	if !cb.allow() {
+		t.Fatal("breaker did not move to half-open after cooldown")
+	}
+	if cb.state != circuitHalfOpen {
+		t.Errorf("state = %v, want circuitHalfOpen", cb.state)
+	}
+}
+
+func TestCircuitBreakerHalfOpenFailureReopens(t *testing.T) {
+	cb := newCircuitBreaker(1, time.Millisecond)
+	cb.recordFailure()
+	time.Sleep(5 * time.Millisecond)
+	cb.allow() // transitions to half-open
+
+	cb.recordFailure()
+
+	if cb.allow() {
+		t.Error("breaker allowed a request immediately after a half-open failure reopened it")
+	}
+}
+
+func TestCircuitBreakerHalfOpenAllowsOnlyOneTrial(t *testing.T) {
+	cb := newCircuitBreaker(1, time.Millisecond)
+	cb.recordFailure()
+	time.Sleep(5 * time.Millisecond)
+
+	if !cb.allow() {
+		t.Fatal("breaker did not allow the first caller through on half-open")
+	}
+	for i := 0; i < 5; i++ {
+		if cb.allow() {
+			t.Fatal("breaker allowed a second concurrent caller through while a trial was in flight")
+		}
+	}
+}
+
+func TestCircuitBreakerSuccessCloses(t *testing.T) {
+	cb := newCircuitBreaker(1, time.Millisecond)
+	cb.recordFailure()
+	time.Sleep(5 * time.Millisecond)
+	cb.allow() // transitions to half-open
+
+	cb.recordSuccess()
+
+	if cb.state != circuitClosed {
+		t.Errorf("state = %v, want circuitClosed", cb.state)
+	}
+	if !cb.allow() {
+		t.Error("breaker did not allow requests after closing")
+	}
+}
+
+func TestResilientTransportReplaysBodyOnRetry(t *testing.T) {
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		attempts++
+		if attempts < 2 {
+			rw.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		rw.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	req, err := http.NewRequest(http.MethodPost, server.URL, nil)
+	if err != nil {
+		t.Fatalf("NewRequest: %v", err)
+	}
+	req.GetBody = func() (io.ReadCloser, error) { return http.NoBody, nil }
+
+	transport := &resilientTransport{
+		base:    http.DefaultTransport,
+		breaker: newCircuitBreaker(5, time.Second),
+		policy:  newTestRetryPolicy(2),
+	}
+
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("RoundTrip: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("final status = %d, want 200", resp.StatusCode)
+	}
+	if attempts != 2 {
+		t.Errorf("attempts = %d, want 2", attempts)
+	}
+}
@@ -0,0 +1,112 @@
+package dynamicreplace
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// RuleConfig scopes rewriting to requests matching Methods and
+// PathRegex, with its own enrichment source and allow-list so a single
+// plugin instance can serve several routes differently.
+type RuleConfig struct {
+	Methods         []string `json:"methods,omitempty"`
+	PathRegex       string   `json:"pathRegex,omitempty"`
+	ReplaceableKeys []string `json:"replaceableKeys,omitempty"`
+	APIURL          string   `json:"apiURL,omitempty"`
+
+	// UIDJSONPath, if set, locates the uid within a JSON body at a path
+	// like "$.user.id" instead of a top-level "uid" field.
+	UIDJSONPath string `json:"uidJSONPath,omitempty"`
+}
+
+// compiledRule is a RuleConfig with its regex compiled once and its
+// method/key lists turned into lookup sets.
+type compiledRule struct {
+	methods     map[string]bool // nil/empty matches every method
+	pathRegex   *regexp.Regexp
+	allowedKeys map[string]bool
+	apiURL      string
+	uidJSONPath string
+}
+
+// compileRules validates and compiles config.Rules, rejecting invalid
+// patterns or incomplete rules at load time rather than per-request.
+func compileRules(rulesConfig []RuleConfig) ([]compiledRule, error) {
+	rules := make([]compiledRule, 0, len(rulesConfig))
+	for i, rc := range rulesConfig {
+		if rc.PathRegex == "" {
+			return nil, fmt.Errorf("rules[%d]: pathRegex is required", i)
+		}
+		pathRegex, err := regexp.Compile(rc.PathRegex)
+		if err != nil {
+			return nil, fmt.Errorf("rules[%d]: invalid pathRegex: %w", i, err)
+		}
+		if rc.APIURL == "" {
+			return nil, fmt.Errorf("rules[%d]: apiURL is required", i)
+		}
+		if len(rc.ReplaceableKeys) == 0 {
+			return nil, fmt.Errorf("rules[%d]: replaceableKeys is required", i)
+		}
+
+		methods := make(map[string]bool, len(rc.Methods))
+		for _, m := range rc.Methods {
+			methods[strings.ToUpper(m)] = true
+		}
+
+		allowedKeys := make(map[string]bool, len(rc.ReplaceableKeys))
+		for _, key := range rc.ReplaceableKeys {
+			allowedKeys[key] = true
+		}
+
+		rules = append(rules, compiledRule{
+			methods:     methods,
+			pathRegex:   pathRegex,
+			allowedKeys: allowedKeys,
+			apiURL:      rc.APIURL,
+			uidJSONPath: rc.UIDJSONPath,
+		})
+	}
+	return rules, nil
+}
+
+// matchRule returns the first rule matching req's method and path, or
+// nil if none match.
+func matchRule(rules []compiledRule, req *http.Request) *compiledRule {
+	for i := range rules {
+		rule := &rules[i]
+		if len(rule.methods) > 0 && !rule.methods[req.Method] {
+			continue
+		}
+		if rule.pathRegex.MatchString(req.URL.Path) {
+			return rule
+		}
+	}
+	return nil
+}
+
+// evalJSONPath resolves a small subset of JSONPath ("$.a.b.c", dotted
+// field access only) against data, returning the string value found.
+func evalJSONPath(path string, data map[string]interface{}) (string, bool) {
+	path = strings.TrimPrefix(path, "$.")
+	path = strings.TrimPrefix(path, "$")
+	if path == "" {
+		return "", false
+	}
+
+	var current interface{} = data
+	for _, seg := range strings.Split(path, ".") {
+		m, ok := current.(map[string]interface{})
+		if !ok {
+			return "", false
+		}
+		current, ok = m[seg]
+		if !ok {
+			return "", false
+		}
+	}
+
+	value, ok := current.(string)
+	return value, ok
+}
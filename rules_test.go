@@ -0,0 +1,125 @@
+package dynamicreplace
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCompileRulesValid(t *testing.T) {
+	rules, err := compileRules([]RuleConfig{
+		{
+			Methods:         []string{"post", "put"},
+			PathRegex:       `^/users/`,
+			ReplaceableKeys: []string{"name"},
+			APIURL:          "http://enrich.example",
+		},
+	})
+	if err != nil {
+		t.Fatalf("compileRules: %v", err)
+	}
+	if len(rules) != 1 {
+		t.Fatalf("len(rules) = %d, want 1", len(rules))
+	}
+	if !rules[0].methods["POST"] || !rules[0].methods["PUT"] {
+		t.Error("compileRules() did not upper-case configured methods")
+	}
+}
+
+func TestCompileRulesRejectsMissingPathRegex(t *testing.T) {
+	_, err := compileRules([]RuleConfig{{APIURL: "http://enrich.example", ReplaceableKeys: []string{"name"}}})
+	if err == nil {
+		t.Error("compileRules() with missing pathRegex: want error, got nil")
+	}
+}
+
+func TestCompileRulesRejectsInvalidPathRegex(t *testing.T) {
+	_, err := compileRules([]RuleConfig{{
+		PathRegex:       "(unterminated",
+		APIURL:          "http://enrich.example",
+		ReplaceableKeys: []string{"name"},
+	}})
+	if err == nil {
+		t.Error("compileRules() with invalid pathRegex: want error, got nil")
+	}
+}
+
+func TestCompileRulesRejectsMissingAPIURL(t *testing.T) {
+	_, err := compileRules([]RuleConfig{{PathRegex: "^/users/", ReplaceableKeys: []string{"name"}}})
+	if err == nil {
+		t.Error("compileRules() with missing apiURL: want error, got nil")
+	}
+}
+
+func TestCompileRulesRejectsMissingReplaceableKeys(t *testing.T) {
+	_, err := compileRules([]RuleConfig{{PathRegex: "^/users/", APIURL: "http://enrich.example"}})
+	if err == nil {
+		t.Error("compileRules() with missing replaceableKeys: want error, got nil")
+	}
+}
+
+func TestMatchRuleByMethodAndPath(t *testing.T) {
+	rules, err := compileRules([]RuleConfig{
+		{Methods: []string{"GET"}, PathRegex: `^/users/`, APIURL: "http://a", ReplaceableKeys: []string{"k"}},
+		{PathRegex: `^/orders/`, APIURL: "http://b", ReplaceableKeys: []string{"k"}},
+	})
+	if err != nil {
+		t.Fatalf("compileRules: %v", err)
+	}
+
+	get := httptest.NewRequest(http.MethodGet, "/users/1", nil)
+	if rule := matchRule(rules, get); rule == nil || rule.apiURL != "http://a" {
+		t.Errorf("matchRule(GET /users/1) = %v, want rule with apiURL http://a", rule)
+	}
+
+	post := httptest.NewRequest(http.MethodPost, "/users/1", nil)
+	if rule := matchRule(rules, post); rule != nil {
+		t.Errorf("matchRule(POST /users/1) = %v, want nil (method doesn't match)", rule)
+	}
+
+	orders := httptest.NewRequest(http.MethodPost, "/orders/1", nil)
+	if rule := matchRule(rules, orders); rule == nil || rule.apiURL != "http://b" {
+		t.Errorf("matchRule(POST /orders/1) = %v, want rule with apiURL http://b (no methods = any)", rule)
+	}
+
+	other := httptest.NewRequest(http.MethodGet, "/widgets/1", nil)
+	if rule := matchRule(rules, other); rule != nil {
+		t.Errorf("matchRule(GET /widgets/1) = %v, want nil", rule)
+	}
+}
+
+func TestEvalJSONPath(t *testing.T) {
+	data := map[string]interface{}{
+		"user": map[string]interface{}{
+			"id":      "abc123",
+			"profile": map[string]interface{}{"name": "ada"},
+		},
+	}
+
+	cases := []struct {
+		path string
+		want string
+		ok   bool
+	}{
+		{"$.user.id", "abc123", true},
+		{"$.user.profile.name", "ada", true},
+		{"user.id", "abc123", true},
+		{"$.user.missing", "", false},
+		{"$.user.id.extra", "", false},
+		{"$", "", false},
+	}
+
+	for _, c := range cases {
+		got, ok := evalJSONPath(c.path, data)
+		if ok != c.ok || got != c.want {
+			t.Errorf("evalJSONPath(%q) = (%q, %v), want (%q, %v)", c.path, got, ok, c.want, c.ok)
+		}
+	}
+}
+
+func TestEvalJSONPathNonStringValue(t *testing.T) {
+	data := map[string]interface{}{"user": map[string]interface{}{"age": float64(42)}}
+	if _, ok := evalJSONPath("$.user.age", data); ok {
+		t.Error("evalJSONPath() on a non-string value: want ok = false")
+	}
+}
@@ -0,0 +1,50 @@
+package dynamicreplace
+
+import "sync"
+
+// call is a single in-flight or completed callGroup.Do invocation.
+type call struct {
+	wg  sync.WaitGroup
+	val interface{}
+	err error
+}
+
+// callGroup coalesces concurrent calls for the same key into one
+// execution, so N requests racing to populate the cache for the same
+// uid only hit the upstream API once. This is a minimal stand-in for
+// golang.org/x/sync/singleflight: Traefik's Yaegi plugin loader
+// interprets a plugin's source tree directly rather than running "go
+// mod download", so this dependency is small enough to own instead of
+// vendoring it.
+type callGroup struct {
+	mu    sync.Mutex
+	calls map[string]*call
+}
+
+// Do executes fn for key, or waits for and returns the result of an
+// already in-flight call for the same key.
+func (g *callGroup) Do(key string, fn func() (interface{}, error)) (interface{}, error) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*call)
+	}
+	if c, ok := g.calls[key]; ok {
+		g.mu.Unlock()
+		c.wg.Wait()
+		return c.val, c.err
+	}
+
+	c := &call{}
+	c.wg.Add(1)
+	g.calls[key] = c
+	g.mu.Unlock()
+
+	c.val, c.err = fn()
+	c.wg.Done()
+
+	g.mu.Lock()
+	delete(g.calls, key)
+	g.mu.Unlock()
+
+	return c.val, c.err
+}
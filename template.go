@@ -0,0 +1,350 @@
+package dynamicreplace
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// templateNode is a single piece of a compiled template: either literal
+// text or a dynamic construct (field, conditional, loop).
+type templateNode interface {
+	render(buf *bytes.Buffer, scope *renderScope, allowedKeys map[string]bool)
+}
+
+// Template is a parsed representation of a request body, ready to be
+// rendered against fetched API data without re-scanning the source text.
+type Template struct {
+	nodes []templateNode
+}
+
+// Render evaluates the template against data in a single pass, writing
+// the result to buf. allowedKeys restricts which top-level fetched
+// fields may be substituted; unresolved placeholders are left verbatim.
+func (t *Template) Render(buf *bytes.Buffer, data map[string]interface{}, allowedKeys map[string]bool) {
+	scope := &renderScope{data: data}
+	for _, n := range t.nodes {
+		n.render(buf, scope, allowedKeys)
+	}
+}
+
+// renderScope is a link in the variable-resolution chain: each #each
+// iteration pushes a new scope whose data shadows its parent's.
+type renderScope struct {
+	data   map[string]interface{}
+	parent *renderScope
+}
+
+// lookup resolves a dotted path (e.g. "user.address.city") against the
+// scope chain, returning the scope the first path segment was found in
+// so callers can tell whether the value came from root (and therefore
+// is subject to allowedKeys) or from an inner loop variable.
+func (s *renderScope) lookup(path []string) (interface{}, *renderScope, bool) {
+	for scope := s; scope != nil; scope = scope.parent {
+		if v, ok := scope.data[path[0]]; ok {
+			for _, seg := range path[1:] {
+				m, ok := v.(map[string]interface{})
+				if !ok {
+					return nil, nil, false
+				}
+				v, ok = m[seg]
+				if !ok {
+					return nil, nil, false
+				}
+			}
+			return v, scope, true
+		}
+	}
+	return nil, nil, false
+}
+
+type textNode struct{ text string }
+
+func (n *textNode) render(buf *bytes.Buffer, _ *renderScope, _ map[string]bool) {
+	buf.WriteString(n.text)
+}
+
+type fieldNode struct {
+	path       []string
+	raw        string // original "{{...}}" text, used when the field can't be resolved
+	hasDefault bool
+	defaultVal string
+}
+
+func (n *fieldNode) render(buf *bytes.Buffer, scope *renderScope, allowedKeys map[string]bool) {
+	value, foundIn, ok := scope.lookup(n.path)
+	if ok && foundIn.parent == nil && !allowedKeys[n.path[0]] {
+		// Found at root scope but not allow-listed: treat as unresolved.
+		ok = false
+	}
+	switch {
+	case ok:
+		buf.WriteString(stringify(value))
+	case n.hasDefault:
+		buf.WriteString(n.defaultVal)
+	default:
+		buf.WriteString(n.raw)
+	}
+}
+
+type ifNode struct {
+	path []string
+	body []templateNode
+}
+
+func (n *ifNode) render(buf *bytes.Buffer, scope *renderScope, allowedKeys map[string]bool) {
+	value, foundIn, ok := scope.lookup(n.path)
+	if ok && foundIn.parent == nil && !allowedKeys[n.path[0]] {
+		ok = false
+	}
+	if !ok || !truthy(value) {
+		return
+	}
+	for _, child := range n.body {
+		child.render(buf, scope, allowedKeys)
+	}
+}
+
+type eachNode struct {
+	path []string
+	body []templateNode
+}
+
+func (n *eachNode) render(buf *bytes.Buffer, scope *renderScope, allowedKeys map[string]bool) {
+	value, foundIn, ok := scope.lookup(n.path)
+	if ok && foundIn.parent == nil && !allowedKeys[n.path[0]] {
+		ok = false
+	}
+	if !ok {
+		return
+	}
+	items, ok := value.([]interface{})
+	if !ok {
+		return
+	}
+	for _, item := range items {
+		itemData, ok := item.(map[string]interface{})
+		if !ok {
+			itemData = map[string]interface{}{"this": item}
+		}
+		child := &renderScope{data: itemData, parent: scope}
+		for _, node := range n.body {
+			node.render(buf, child, allowedKeys)
+		}
+	}
+}
+
+func truthy(v interface{}) bool {
+	switch val := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return val
+	case string:
+		return val != ""
+	case []interface{}:
+		return len(val) > 0
+	case map[string]interface{}:
+		return len(val) > 0
+	case float64:
+		return val != 0
+	default:
+		return true
+	}
+}
+
+func stringify(v interface{}) string {
+	switch val := v.(type) {
+	case string:
+		return val
+	case float64:
+		return strconv.FormatFloat(val, 'f', -1, 64)
+	case nil:
+		return ""
+	case bool:
+		return strconv.FormatBool(val)
+	default:
+		b, err := json.Marshal(val)
+		if err != nil {
+			return fmt.Sprintf("%v", val)
+		}
+		return string(b)
+	}
+}
+
+// parseTemplate compiles src into a Template. Supported syntax:
+//
+//	{{key}}                        field, dotted path for nested access
+//	{{key|default:"fallback"}}     field with a default value
+//	{{#if key}}...{{/if}}          conditional block
+//	{{#each items}}...{{/each}}    loop over an array, body re-scoped per item
+func parseTemplate(src string) (*Template, error) {
+	nodes, rest, err := parseNodes(src, "")
+	if err != nil {
+		return nil, err
+	}
+	if rest != "" {
+		return nil, fmt.Errorf("dynamicreplace: unexpected trailing content in template")
+	}
+	return &Template{nodes: nodes}, nil
+}
+
+// parseNodes parses template nodes until it hits EOF or the closing tag
+// named by until (e.g. "if" or "each"), returning the unconsumed
+// remainder of src starting just after that closing tag.
+func parseNodes(src, until string) ([]templateNode, string, error) {
+	var nodes []templateNode
+	for {
+		start := strings.Index(src, "{{")
+		if start == -1 {
+			if until != "" {
+				return nil, "", fmt.Errorf("dynamicreplace: missing {{/%s}}", until)
+			}
+			nodes = append(nodes, &textNode{text: src})
+			return nodes, "", nil
+		}
+		if start > 0 {
+			nodes = append(nodes, &textNode{text: src[:start]})
+		}
+		end := strings.Index(src[start:], "}}")
+		if end == -1 {
+			return nil, "", fmt.Errorf("dynamicreplace: unterminated tag")
+		}
+		end += start
+		tag := strings.TrimSpace(src[start+2 : end])
+		remainder := src[end+2:]
+
+		switch {
+		case until != "" && tag == "/"+until:
+			return nodes, remainder, nil
+		case strings.HasPrefix(tag, "#if "):
+			key := strings.TrimSpace(strings.TrimPrefix(tag, "#if "))
+			body, rest, err := parseNodes(remainder, "if")
+			if err != nil {
+				return nil, "", err
+			}
+			nodes = append(nodes, &ifNode{path: strings.Split(key, "."), body: body})
+			src = rest
+			continue
+		case strings.HasPrefix(tag, "#each "):
+			key := strings.TrimSpace(strings.TrimPrefix(tag, "#each "))
+			body, rest, err := parseNodes(remainder, "each")
+			if err != nil {
+				return nil, "", err
+			}
+			nodes = append(nodes, &eachNode{path: strings.Split(key, "."), body: body})
+			src = rest
+			continue
+		case strings.HasPrefix(tag, "/"):
+			return nil, "", fmt.Errorf("dynamicreplace: unexpected closing tag %q", tag)
+		default:
+			nodes = append(nodes, parseField(tag, src[start:end+2]))
+			src = remainder
+			continue
+		}
+	}
+}
+
+// parseField parses the inside of a "{{...}}" field tag, handling the
+// optional "|default:\"...\"" suffix. raw is the full original tag text.
+func parseField(tag, raw string) *fieldNode {
+	pipeIdx := strings.Index(tag, "|")
+	if pipeIdx == -1 {
+		return &fieldNode{path: strings.Split(tag, "."), raw: raw}
+	}
+	key := strings.TrimSpace(tag[:pipeIdx])
+	modifier := strings.TrimSpace(tag[pipeIdx+1:])
+	const defaultPrefix = "default:"
+	if !strings.HasPrefix(modifier, defaultPrefix) {
+		return &fieldNode{path: strings.Split(key, "."), raw: raw}
+	}
+	defaultVal := strings.TrimSpace(strings.TrimPrefix(modifier, defaultPrefix))
+	defaultVal = strings.Trim(defaultVal, `"`)
+	return &fieldNode{path: strings.Split(key, "."), raw: raw, hasDefault: true, defaultVal: defaultVal}
+}
+
+// maxCachedTemplates bounds globalTemplateCache to this many entries,
+// evicting least-recently-used templates so a stream of distinct
+// request bodies can't grow it without bound.
+const maxCachedTemplates = 1000
+
+// templateCache holds compiled templates keyed by a hash of the raw
+// request body, so a repeat of the exact same bytes (a client retry, or
+// fixed-shape traffic with no per-request nonce/timestamp/session id)
+// skips re-parsing. Bodies that vary outside their templated fields
+// won't hash-match a prior request, so this is best understood as
+// bounding memory on an LRU rather than a general-purpose hit-rate
+// optimization. It is an LRU with a fixed capacity, the same pattern
+// memoryCache uses for the upstream-data cache.
+type templateCache struct {
+	mu         sync.Mutex
+	maxEntries int
+	order      *list.List
+	entries    map[string]*list.Element
+}
+
+type templateCacheEntry struct {
+	key  string
+	tmpl *Template
+}
+
+var globalTemplateCache = newTemplateCache(maxCachedTemplates)
+
+func newTemplateCache(maxEntries int) *templateCache {
+	return &templateCache{
+		maxEntries: maxEntries,
+		order:      list.New(),
+		entries:    make(map[string]*list.Element),
+	}
+}
+
+func (c *templateCache) getOrCompile(body []byte) (*Template, error) {
+	key := hashBody(body)
+
+	c.mu.Lock()
+	if elem, ok := c.entries[key]; ok {
+		c.order.MoveToFront(elem)
+		tmpl := elem.Value.(*templateCacheEntry).tmpl
+		c.mu.Unlock()
+		return tmpl, nil
+	}
+	c.mu.Unlock()
+
+	tmpl, err := parseTemplate(string(body))
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[key]; ok {
+		c.order.MoveToFront(elem)
+		return elem.Value.(*templateCacheEntry).tmpl, nil
+	}
+
+	elem := c.order.PushFront(&templateCacheEntry{key: key, tmpl: tmpl})
+	c.entries[key] = elem
+
+	for c.order.Len() > c.maxEntries {
+		oldest := c.order.Back()
+		if oldest == nil {
+			break
+		}
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*templateCacheEntry).key)
+	}
+
+	return tmpl, nil
+}
+
+func hashBody(body []byte) string {
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
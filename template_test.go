@@ -0,0 +1,151 @@
+package dynamicreplace
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestTemplateFieldAccess(t *testing.T) {
+	tmpl, err := parseTemplate(`{"id":"{{user.id}}","name":"{{user.name}}"}`)
+	if err != nil {
+		t.Fatalf("parseTemplate: %v", err)
+	}
+
+	data := map[string]interface{}{
+		"user": map[string]interface{}{"id": "42", "name": "ada"},
+	}
+	allowed := map[string]bool{"user": true}
+
+	var buf bytes.Buffer
+	tmpl.Render(&buf, data, allowed)
+
+	want := `{"id":"42","name":"ada"}`
+	if got := buf.String(); got != want {
+		t.Errorf("Render() = %q, want %q", got, want)
+	}
+}
+
+func TestTemplateFieldRespectsAllowedKeys(t *testing.T) {
+	tmpl, err := parseTemplate(`{{secret}}`)
+	if err != nil {
+		t.Fatalf("parseTemplate: %v", err)
+	}
+
+	data := map[string]interface{}{"secret": "leaked"}
+
+	var buf bytes.Buffer
+	tmpl.Render(&buf, data, map[string]bool{})
+
+	if got := buf.String(); got != `{{secret}}` {
+		t.Errorf("Render() = %q, want raw tag left untouched", got)
+	}
+}
+
+func TestTemplateFieldDefault(t *testing.T) {
+	tmpl, err := parseTemplate(`{{missing|default:"fallback"}}`)
+	if err != nil {
+		t.Fatalf("parseTemplate: %v", err)
+	}
+
+	var buf bytes.Buffer
+	tmpl.Render(&buf, map[string]interface{}{}, map[string]bool{})
+
+	if got := buf.String(); got != "fallback" {
+		t.Errorf("Render() = %q, want %q", got, "fallback")
+	}
+}
+
+func TestTemplateIf(t *testing.T) {
+	tmpl, err := parseTemplate(`{{#if active}}yes{{/if}}{{#if inactive}}no{{/if}}`)
+	if err != nil {
+		t.Fatalf("parseTemplate: %v", err)
+	}
+
+	data := map[string]interface{}{"active": true, "inactive": false}
+	allowed := map[string]bool{"active": true, "inactive": true}
+
+	var buf bytes.Buffer
+	tmpl.Render(&buf, data, allowed)
+
+	if got := buf.String(); got != "yes" {
+		t.Errorf("Render() = %q, want %q", got, "yes")
+	}
+}
+
+func TestTemplateEach(t *testing.T) {
+	tmpl, err := parseTemplate(`{{#each items}}[{{name}}]{{/each}}`)
+	if err != nil {
+		t.Fatalf("parseTemplate: %v", err)
+	}
+
+	data := map[string]interface{}{
+		"items": []interface{}{
+			map[string]interface{}{"name": "a"},
+			map[string]interface{}{"name": "b"},
+		},
+	}
+	allowed := map[string]bool{"items": true}
+
+	var buf bytes.Buffer
+	tmpl.Render(&buf, data, allowed)
+
+	if got := buf.String(); got != "[a][b]" {
+		t.Errorf("Render() = %q, want %q", got, "[a][b]")
+	}
+}
+
+func TestTemplateUnterminatedTag(t *testing.T) {
+	if _, err := parseTemplate(`{{user.id`); err == nil {
+		t.Error("parseTemplate() with unterminated tag: want error, got nil")
+	}
+}
+
+func TestTemplateMissingClosingIf(t *testing.T) {
+	if _, err := parseTemplate(`{{#if active}}yes`); err == nil {
+		t.Error("parseTemplate() with missing {{/if}}: want error, got nil")
+	}
+}
+
+func TestTemplateCacheReusesCompiledTemplate(t *testing.T) {
+	c := newTemplateCache(10)
+	body := []byte(`{{user.id}}`)
+
+	first, err := c.getOrCompile(body)
+	if err != nil {
+		t.Fatalf("getOrCompile: %v", err)
+	}
+	second, err := c.getOrCompile(body)
+	if err != nil {
+		t.Fatalf("getOrCompile: %v", err)
+	}
+	if first != second {
+		t.Error("getOrCompile() recompiled an already-cached body")
+	}
+}
+
+func TestTemplateCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	c := newTemplateCache(2)
+
+	first, err := c.getOrCompile([]byte(`{{a}}`))
+	if err != nil {
+		t.Fatalf("getOrCompile: %v", err)
+	}
+	if _, err := c.getOrCompile([]byte(`{{b}}`)); err != nil {
+		t.Fatalf("getOrCompile: %v", err)
+	}
+	if _, err := c.getOrCompile([]byte(`{{c}}`)); err != nil {
+		t.Fatalf("getOrCompile: %v", err)
+	}
+
+	if c.order.Len() != 2 {
+		t.Fatalf("cache holds %d entries, want 2", c.order.Len())
+	}
+
+	firstAgain, err := c.getOrCompile([]byte(`{{a}}`))
+	if err != nil {
+		t.Fatalf("getOrCompile: %v", err)
+	}
+	if firstAgain == first {
+		t.Error("getOrCompile() returned the evicted entry instead of recompiling")
+	}
+}